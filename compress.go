@@ -0,0 +1,314 @@
+package httputil
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultMinCompressSize is the minimum response size, in bytes,
+// before Compress will attempt to compress a response.
+const defaultMinCompressSize = 1024
+
+// compressedContentTypePrefixes lists Content-Type prefixes that are
+// already compressed and therefore not worth compressing again.
+var compressedContentTypePrefixes = []string{"image/", "video/", "audio/"}
+
+// compressedContentTypes lists exact Content-Types that are already
+// compressed.
+var compressedContentTypes = map[string]bool{
+	"application/zip":             true,
+	"application/gzip":            true,
+	"application/x-gzip":          true,
+	"application/x-bzip2":         true,
+	"application/x-7z-compressed": true,
+	"application/pdf":             true,
+	"font/woff":                   true,
+	"font/woff2":                  true,
+}
+
+// Compress instructs the handler to compress response bodies with
+// gzip or deflate, whichever the client prefers via Accept-Encoding.
+// Responses smaller than CompressMinSize, responses whose Content-Type
+// is already compressed, and responses where the inner handler set
+// its own Content-Encoding are left untouched.
+func (h *Handler) Compress() {
+	h.compress = true
+}
+
+// CompressMinSize sets the minimum response size, in bytes, before
+// compression is attempted. Responses are buffered up to this many
+// bytes in order to make the decision. It defaults to 1024.
+func (h *Handler) CompressMinSize(n int) {
+	h.compressMinSize = n
+}
+
+// CompressLevel sets the gzip/flate compression level (see the level
+// constants in compress/gzip). It defaults to gzip.DefaultCompression.
+// A level of 0 (gzip.NoCompression) is treated as unset and also
+// selects gzip.DefaultCompression.
+func (h *Handler) CompressLevel(level int) {
+	h.compressLevel = level
+}
+
+// compressWriter wraps an http.ResponseWriter (always an
+// *httputil.ResponseWriter in practice) and transparently compresses
+// the body once it's known whether compression is worthwhile.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	level    int
+
+	status      int
+	wroteHeader bool
+
+	buf        []byte
+	decided    bool
+	compressor io.WriteCloser
+}
+
+// newCompressWriter returns a compressWriter for r, or ok == false if
+// the client doesn't accept gzip or deflate and no wrapping is
+// necessary.
+func newCompressWriter(w http.ResponseWriter, r *http.Request, minSize, level int) (cw *compressWriter, ok bool) {
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return nil, false
+	}
+	if minSize == 0 {
+		minSize = defaultMinCompressSize
+	}
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize, level: level}, true
+}
+
+// negotiateEncoding picks gzip or deflate out of an Accept-Encoding
+// header, preferring gzip when both are acceptable.
+func negotiateEncoding(acceptEncoding string) string {
+	gzipOK, deflateOK := false, false
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, q := part, 1.0
+		if i := strings.IndexByte(part, ';'); i >= 0 {
+			name = part[:i]
+			if qi := strings.Index(part[i+1:], "q="); qi >= 0 {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(part[i+1+qi+2:]), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		name = strings.TrimSpace(name)
+		if q <= 0 {
+			continue
+		}
+		switch name {
+		case "gzip":
+			gzipOK = true
+		case "deflate":
+			deflateOK = true
+		}
+	}
+
+	switch {
+	case gzipOK:
+		return "gzip"
+	case deflateOK:
+		return "deflate"
+	default:
+		return ""
+	}
+}
+
+// Unwrap returns the ResponseWriter that compressWriter wraps,
+// following the convention used by http.ResponseController, so code
+// that needs the concrete *httputil.ResponseWriter (such as Error)
+// can reach it through compression.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Hijack implements http.Hijacker by delegating to the wrapped
+// ResponseWriter, so compression doesn't undo WebSocket support.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hj.Hijack()
+}
+
+// Flush implements http.Flusher. A handler that flushes wants its
+// buffered bytes on the wire now, so if the response hasn't yet
+// reached minSize, Flush abandons compression for the rest of the
+// response and writes what's buffered through as-is — bytes already
+// on their way to the client can't retroactively be compressed.
+// Otherwise it flushes the compressor before flushing the wrapped
+// ResponseWriter. Either way, streaming responses such as SSE keep
+// working; they just won't be compressed once they've flushed early.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.passthrough()
+	}
+	if f, ok := cw.compressor.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CloseNotify implements http.CloseNotifier by delegating to the
+// wrapped ResponseWriter.
+//
+// Deprecated: as with the standard library's http.CloseNotifier,
+// prefer Request.Context().Done() in new code.
+func (cw *compressWriter) CloseNotify() <-chan bool {
+	if cn, ok := cw.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return nil
+}
+
+// Push implements http.Pusher by delegating to the wrapped
+// ResponseWriter, so compression doesn't undo HTTP/2 server push.
+func (cw *compressWriter) Push(target string, opts *http.PushOptions) error {
+	p, ok := cw.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return p.Push(target, opts)
+}
+
+// WriteHeader records the status for later, since the
+// Content-Encoding (and Content-Length removal) must be decided
+// before any bytes reach the client.
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+}
+
+// Write buffers the response until minSize bytes have accumulated,
+// at which point it commits to compressing (or not) and flushes.
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.decided {
+		if cw.compressor != nil {
+			return cw.compressor.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) < cw.minSize {
+		return len(p), nil
+	}
+	if err := cw.startCompression(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// startCompression makes the compression decision once the buffered
+// body has reached minSize. If the handler set its own
+// Content-Encoding or the Content-Type is already compressed, it
+// writes the buffer through as-is; otherwise it flushes the buffer
+// through the chosen encoder.
+func (cw *compressWriter) startCompression() error {
+	cw.decided = true
+
+	ctype := cw.Header().Get("Content-Type")
+	compress := cw.Header().Get("Content-Encoding") == "" && !isCompressedContentType(ctype)
+
+	if compress {
+		cw.Header().Set("Content-Encoding", cw.encoding)
+		cw.Header().Del("Content-Length")
+		cw.Header().Add("Vary", "Accept-Encoding")
+	}
+	if cw.wroteHeader {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+	if !compress {
+		_, err := cw.ResponseWriter.Write(cw.buf)
+		cw.buf = nil
+		return err
+	}
+
+	switch cw.encoding {
+	case "gzip":
+		gz, err := gzip.NewWriterLevel(cw.ResponseWriter, cw.level)
+		if err != nil {
+			return err
+		}
+		cw.compressor = gz
+	case "deflate":
+		fl, err := flate.NewWriter(cw.ResponseWriter, cw.level)
+		if err != nil {
+			return err
+		}
+		cw.compressor = fl
+	}
+	_, err := cw.compressor.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+// passthrough commits to NOT compressing the response and writes any
+// buffered bytes through as-is. It's used both when the response
+// turns out smaller than minSize (Close) and when the handler flushes
+// before that decision is made (Flush).
+func (cw *compressWriter) passthrough() error {
+	cw.decided = true
+	if cw.wroteHeader {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	_, err := cw.ResponseWriter.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+// Close flushes any buffered body that never reached minSize (and so
+// was never compressed) and closes the underlying compressor, if one
+// was started.
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		return cw.passthrough()
+	}
+	if cw.compressor != nil {
+		return cw.compressor.Close()
+	}
+	return nil
+}
+
+// isCompressedContentType reports whether ctype names a body format
+// that's already compressed and not worth compressing again.
+func isCompressedContentType(ctype string) bool {
+	if i := strings.IndexByte(ctype, ';'); i >= 0 {
+		ctype = ctype[:i]
+	}
+	ctype = strings.ToLower(strings.TrimSpace(ctype))
+	if ctype == "" {
+		return false
+	}
+	if compressedContentTypes[ctype] {
+		return true
+	}
+	for _, prefix := range compressedContentTypePrefixes {
+		if strings.HasPrefix(ctype, prefix) {
+			return true
+		}
+	}
+	return false
+}
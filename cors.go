@@ -0,0 +1,123 @@
+package httputil
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	headerOrigin           = "Origin"
+	headerACRequestMethod  = "Access-Control-Request-Method"
+	headerACRequestHeaders = "Access-Control-Request-Headers"
+	headerACAllowOrigin    = "Access-Control-Allow-Origin"
+	headerACAllowMethods   = "Access-Control-Allow-Methods"
+	headerACAllowHeaders   = "Access-Control-Allow-Headers"
+	headerACCredentials    = "Access-Control-Allow-Credentials"
+	headerACExposeHeaders  = "Access-Control-Expose-Headers"
+	headerACMaxAge         = "Access-Control-Max-Age"
+	headerVary             = "Vary"
+)
+
+// AllowOrigins instructs the handler to add CORS headers to requests
+// whose Origin matches one of the given origins. A single origin of
+// "*" allows any origin, except when AllowCredentials(true) is set:
+// the CORS specification forbids combining a wildcard origin with
+// credentialed requests, so "*" is then ignored and only origins
+// explicitly listed are allowed.
+func (h *Handler) AllowOrigins(origins ...string) {
+	h.corsOrigins = origins
+}
+
+// AllowCredentials instructs the handler to set
+// Access-Control-Allow-Credentials on CORS responses, permitting
+// browsers to send cookies and HTTP auth with cross-origin requests.
+func (h *Handler) AllowCredentials(allow bool) {
+	h.corsCredentials = allow
+}
+
+// ExposeHeaders sets the response headers, beyond the CORS-safelisted
+// ones, that browsers are permitted to read from a cross-origin
+// response.
+func (h *Handler) ExposeHeaders(headers ...string) {
+	h.corsExposeHeaders = headers
+}
+
+// AllowHeaders sets the request headers a browser may send when
+// making the actual cross-origin request, as announced in response
+// to a preflight request.
+func (h *Handler) AllowHeaders(headers ...string) {
+	h.corsHeaders = headers
+}
+
+// MaxAge sets how long a browser may cache the result of a preflight
+// request.
+func (h *Handler) MaxAge(d time.Duration) {
+	h.corsMaxAge = d
+}
+
+// corsAllowOrigin reports the value to send as
+// Access-Control-Allow-Origin for the given request Origin, and
+// whether the origin is allowed at all.
+func (h *Handler) corsAllowOrigin(origin string) (string, bool) {
+	if origin == "" {
+		return "", false
+	}
+	for _, o := range h.corsOrigins {
+		if o == origin {
+			return origin, true
+		}
+		if o == "*" && !h.corsCredentials {
+			return "*", true
+		}
+	}
+	return "", false
+}
+
+// handleCORS applies CORS headers to the response when the request
+// carries an allowed Origin header, and fully answers preflight
+// OPTIONS requests from such an origin. Requests from an origin that
+// isn't allowed get only the Vary header and are passed on as usual.
+// It reports whether the request has been completely handled, in
+// which case the caller must not invoke the inner handler.
+func (h *Handler) handleCORS(w http.ResponseWriter, r *http.Request) bool {
+	if h.corsOrigins == nil {
+		return false
+	}
+
+	w.Header().Add(headerVary, headerOrigin)
+
+	allowOrigin, ok := h.corsAllowOrigin(r.Header.Get(headerOrigin))
+	if !ok {
+		return false
+	}
+
+	w.Header().Set(headerACAllowOrigin, allowOrigin)
+	if h.corsCredentials {
+		w.Header().Set(headerACCredentials, "true")
+	}
+
+	preflight := r.Method == http.MethodOptions && r.Header.Get(headerACRequestMethod) != ""
+	if !preflight {
+		if len(h.corsExposeHeaders) > 0 {
+			w.Header().Set(headerACExposeHeaders, strings.Join(h.corsExposeHeaders, ", "))
+		}
+		return false
+	}
+
+	if len(h.allow) > 0 {
+		w.Header().Set(headerACAllowMethods, strings.Join(h.allow, ", "))
+	}
+	if len(h.corsHeaders) > 0 {
+		w.Header().Set(headerACAllowHeaders, strings.Join(h.corsHeaders, ", "))
+	} else if reqHeaders := r.Header.Get(headerACRequestHeaders); reqHeaders != "" {
+		w.Header().Set(headerACAllowHeaders, reqHeaders)
+	}
+	if h.corsMaxAge > 0 {
+		w.Header().Set(headerACMaxAge, strconv.Itoa(int(h.corsMaxAge/time.Second)))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
@@ -0,0 +1,136 @@
+package httputil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Formatter renders an *Access event as a single log line, without a
+// trailing newline.
+type Formatter interface {
+	Format(a *Access) []byte
+}
+
+// CommonFormatter renders Access events in the Common Log Format.
+// See <http://httpd.apache.org/docs/1.3/logs.html#common>.
+var CommonFormatter Formatter = commonFormatter{}
+
+// CombinedFormatter renders Access events in the Combined Log Format,
+// which extends the Common Log Format with the Referer and
+// User-Agent request headers.
+var CombinedFormatter Formatter = combinedFormatter{}
+
+// JSONFormatter renders Access events as a single line of JSON with
+// typed fields, suitable for ingestion by structured log pipelines
+// such as ELK or Loki without post-processing regex.
+var JSONFormatter Formatter = jsonFormatter{}
+
+type commonFormatter struct{}
+
+func (commonFormatter) Format(a *Access) []byte {
+	return []byte(fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %d`,
+		a.RemoteAddr,
+		a.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		a.Method,
+		a.RequestURI,
+		a.Proto,
+		a.StatusCode,
+		a.ContentLength))
+}
+
+type combinedFormatter struct{}
+
+func (combinedFormatter) Format(a *Access) []byte {
+	return []byte(fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %d "%s" "%s"`,
+		a.RemoteAddr,
+		a.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		a.Method,
+		a.RequestURI,
+		a.Proto,
+		a.StatusCode,
+		a.ContentLength,
+		a.Referer,
+		a.UserAgent))
+}
+
+type jsonFormatter struct{}
+
+// jsonAccess is the JSON wire representation of an Access event.
+// encoding/json escapes control characters and invalid UTF-8 in
+// string fields for us.
+type jsonAccess struct {
+	RemoteAddr string `json:"remote_addr"`
+	Time       string `json:"time"`
+	Method     string `json:"method"`
+	URI        string `json:"uri"`
+	Proto      string `json:"proto"`
+	Status     int    `json:"status"`
+	Bytes      int64  `json:"bytes"`
+	Referer    string `json:"referer"`
+	UserAgent  string `json:"user_agent"`
+	DurationMs int64  `json:"duration_ms"`
+	RequestID  string `json:"request_id,omitempty"`
+}
+
+func (jsonFormatter) Format(a *Access) []byte {
+	j := jsonAccess{
+		RemoteAddr: a.RemoteAddr,
+		Time:       a.Time.Format(time.RFC3339),
+		Method:     a.Method,
+		URI:        a.RequestURI,
+		Proto:      a.Proto,
+		Status:     a.StatusCode,
+		Bytes:      a.ContentLength,
+		Referer:    a.Referer,
+		UserAgent:  a.UserAgent,
+		DurationMs: int64(a.Duration / time.Millisecond),
+	}
+	if a.Request != nil {
+		j.RequestID = a.Request.Header.Get("X-Request-Id")
+	}
+
+	b, err := json.Marshal(j)
+	if err != nil {
+		return []byte("{}")
+	}
+	return b
+}
+
+// Logger writes every Access event sent to Notify to an io.Writer,
+// rendered with a Formatter, one line per request. Its Formatter is
+// the one associated with its channel via Notify, so SetFormatter
+// works by updating that association.
+type Logger struct {
+	w  io.Writer
+	ch chan *Access
+}
+
+// NewLogger returns a Logger that writes access events to w,
+// rendered with CommonFormatter until SetFormatter says otherwise.
+func NewLogger(w io.Writer) *Logger {
+	ch := make(chan *Access, 1)
+	Notify(ch, CommonFormatter)
+
+	l := &Logger{w: w, ch: ch}
+	go l.run()
+
+	return l
+}
+
+// SetFormatter changes the Formatter used to render subsequent
+// Access events.
+func (l *Logger) SetFormatter(f Formatter) {
+	setNotifyFormatter(l.ch, f)
+}
+
+func (l *Logger) run() {
+	for a := range l.ch {
+		f, ok := formatterFor(l.ch)
+		if !ok {
+			f = CommonFormatter
+		}
+		l.w.Write(append(f.Format(a), '\n'))
+	}
+}
@@ -0,0 +1,54 @@
+package httputil
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// Hijack implements http.Hijacker by delegating to the wrapped
+// http.ResponseWriter, so WebSocket and other connection upgrades
+// work behind a Handler. It returns http.ErrNotSupported if the
+// wrapped writer doesn't support hijacking.
+func (rw *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := rw.inner.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hj.Hijack()
+}
+
+// Flush implements http.Flusher by delegating to the wrapped
+// http.ResponseWriter, so streaming responses such as SSE work behind
+// a Handler. It is a no-op if the wrapped writer doesn't support
+// flushing.
+func (rw *ResponseWriter) Flush() {
+	if f, ok := rw.inner.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CloseNotify implements http.CloseNotifier by delegating to the
+// wrapped http.ResponseWriter. It returns a nil channel, which never
+// fires, if the wrapped writer doesn't support close notification.
+//
+// Deprecated: as with the standard library's http.CloseNotifier,
+// prefer Request.Context().Done() in new code.
+func (rw *ResponseWriter) CloseNotify() <-chan bool {
+	if cn, ok := rw.inner.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return nil
+}
+
+// Push implements http.Pusher by delegating to the wrapped
+// http.ResponseWriter, so HTTP/2 server push works behind a Handler.
+// It returns http.ErrNotSupported if the wrapped writer doesn't
+// support push.
+func (rw *ResponseWriter) Push(target string, opts *http.PushOptions) error {
+	p, ok := rw.inner.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return p.Push(target, opts)
+}
@@ -9,9 +9,9 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -21,12 +21,57 @@ const CommonLogFmt = `%s - - [%s] "%s %s %s" %d %d "%s" "%s" %d`
 
 var (
 	LogFmt = CommonLogFmt // Log format to use
-	notify = []chan *Access{}
+
+	notifyMu sync.RWMutex
+	notify   = []notifyTarget{}
 )
 
-// Notify sends all HTTP access events to the specified channel.
-func Notify(ch chan *Access) {
-	notify = append(notify, ch)
+// notifyTarget pairs a channel registered via Notify with the
+// Formatter, if any, its owner associated with it; see
+// setNotifyFormatter and formatterFor.
+type notifyTarget struct {
+	ch        chan *Access
+	formatter Formatter
+}
+
+// Notify sends all HTTP access events to the specified channel. An
+// optional Formatter may be associated with the channel, recallable
+// via formatterFor; Logger uses this to let SetFormatter change how
+// it renders events after construction.
+func Notify(ch chan *Access, formatter ...Formatter) {
+	var f Formatter
+	if len(formatter) > 0 {
+		f = formatter[0]
+	}
+
+	notifyMu.Lock()
+	notify = append(notify, notifyTarget{ch: ch, formatter: f})
+	notifyMu.Unlock()
+}
+
+// setNotifyFormatter updates the Formatter associated with ch.
+func setNotifyFormatter(ch chan *Access, f Formatter) {
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+	for i := range notify {
+		if notify[i].ch == ch {
+			notify[i].formatter = f
+			return
+		}
+	}
+}
+
+// formatterFor returns the Formatter associated with ch via Notify,
+// and whether one was supplied.
+func formatterFor(ch chan *Access) (Formatter, bool) {
+	notifyMu.RLock()
+	defer notifyMu.RUnlock()
+	for _, t := range notify {
+		if t.ch == ch {
+			return t.formatter, t.formatter != nil
+		}
+	}
+	return nil, false
 }
 
 // Access represents a single HTTP access event (an answered request).
@@ -37,11 +82,15 @@ type Access struct {
 	RequestURI    string
 	Proto         string
 	StatusCode    int
-	ContentLength int64
+	ContentLength int64 // bytes actually written to the response, not the request's Content-Length
 	Referer       string
 	UserAgent     string
 	Duration      time.Duration
 	Request       *http.Request
+	ClientIP      string
+	ClientProto   string
+	Panic         interface{}
+	Stack         []byte
 }
 
 // String returns the string representation of an *Access according
@@ -66,6 +115,21 @@ type Handler struct {
 	contentType string
 	accept      string
 	allow       []string
+
+	corsOrigins       []string
+	corsCredentials   bool
+	corsExposeHeaders []string
+	corsHeaders       []string
+	corsMaxAge        time.Duration
+
+	compress        bool
+	compressMinSize int
+	compressLevel   int
+
+	trustedProxies []*net.IPNet
+
+	printStack   bool
+	panicHandler PanicHandler
 }
 
 // NewHandler returns a new Handler which wraps the given http.Handler.
@@ -73,6 +137,7 @@ func NewHandler(inner http.Handler, ctype string) *Handler {
 	return &Handler{
 		inner:       inner,
 		contentType: ctype,
+		printStack:  true,
 	}
 }
 
@@ -93,9 +158,10 @@ func (h *Handler) Allow(methods ...string) {
 // ResponseWriter wraps an http.ResponseWriter with
 // additional capabilities.
 type ResponseWriter struct {
-	StatusCode  int
-	ContentType string
-	inner       http.ResponseWriter
+	StatusCode   int
+	ContentType  string
+	BytesWritten int64
+	inner        http.ResponseWriter
 }
 
 // HasStatus returns whether or not the ResponseWriter has
@@ -111,9 +177,12 @@ func (rw *ResponseWriter) WriteHeader(status int) {
 	rw.inner.WriteHeader(status)
 }
 
-// Write wraps (*http.ResponseWriter).Write.
+// Write wraps (*http.ResponseWriter).Write and counts the bytes
+// actually written, via BytesWritten.
 func (rw *ResponseWriter) Write(b []byte) (int, error) {
-	return rw.inner.Write(b)
+	n, err := rw.inner.Write(b)
+	rw.BytesWritten += int64(n)
+	return n, err
 }
 
 // Write wraps (*http.ResponseWriter).Header.
@@ -128,7 +197,7 @@ func (rw *ResponseWriter) Header() http.Header {
 // and the error string "oops!", the response body would be
 // `{"error":"oops!"}`
 func Error(w http.ResponseWriter, err string, code int) {
-	if rw, ok := w.(*ResponseWriter); ok {
+	if rw, ok := unwrapResponseWriter(w); ok {
 		switch rw.ContentType {
 		case "application/json":
 			err = fmt.Sprintf(`{"error":%s,"status":%d}`, strconv.QuoteToASCII(err), code)
@@ -143,8 +212,28 @@ func Error(w http.ResponseWriter, err string, code int) {
 	http.Error(w, err, code)
 }
 
-func logRequest(r *http.Request, statusCode int, delta time.Duration) {
-	var referer, remoteAddr, userAgent string
+// unwrapResponseWriter finds the underlying *ResponseWriter beneath
+// any wrappers (such as compressWriter) that implement
+// Unwrap() http.ResponseWriter, following the convention used by
+// http.ResponseController.
+func unwrapResponseWriter(w http.ResponseWriter) (*ResponseWriter, bool) {
+	for {
+		if rw, ok := w.(*ResponseWriter); ok {
+			return rw, true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil, false
+		}
+		w = u.Unwrap()
+	}
+}
+
+func logRequest(r *http.Request, statusCode int, bytesWritten int64, delta time.Duration, p interface{}, stack []byte) {
+	var referer, userAgent string
+
+	remoteAddr := ClientIP(r)
+	clientProto := ClientProto(r)
 
 	if h, ok := r.Header["Referer"]; ok {
 		referer = h[0]
@@ -152,35 +241,32 @@ func logRequest(r *http.Request, statusCode int, delta time.Duration) {
 		referer = "-"
 	}
 
-	if h, ok := r.Header["X-Forwarded-For"]; ok {
-		remoteAddr = h[0]
-	} else {
-		if host, _, err := net.SplitHostPort(r.RemoteAddr); err != nil {
-			remoteAddr = "?"
-		} else {
-			remoteAddr = host
-		}
-	}
-
 	if h, ok := r.Header["User-Agent"]; ok {
 		userAgent = h[0]
 	} else {
 		userAgent = "-"
 	}
 
-	for _, ch := range notify {
-		ch <- &Access{
+	notifyMu.RLock()
+	defer notifyMu.RUnlock()
+
+	for _, target := range notify {
+		target.ch <- &Access{
 			remoteAddr,
 			time.Now(),
 			r.Method,
 			r.RequestURI,
 			r.Proto,
 			statusCode,
-			r.ContentLength,
+			bytesWritten,
 			referer,
 			userAgent,
 			delta,
 			r,
+			remoteAddr,
+			clientProto,
+			p,
+			stack,
 		}
 	}
 }
@@ -190,21 +276,23 @@ func logRequest(r *http.Request, statusCode int, delta time.Duration) {
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var delta time.Duration
 
+	r = h.resolveClient(r)
+
 	rw := &ResponseWriter{inner: w, ContentType: h.contentType}
 	rw.Header().Set("Content-Type", h.contentType)
 
 	defer func() {
+		var p interface{}
+		var stack []byte
 		if e := recover(); e != nil {
-			if !rw.HasStatus() {
-				rw.WriteHeader(http.StatusInternalServerError)
-			}
-			log.Printf("panic: %v", e)
-			debug.PrintStack()
+			p = e
+			stack = h.onPanic(rw, r, e)
 		}
+
 		if rw.HasStatus() {
-			logRequest(r, rw.StatusCode, delta)
+			logRequest(r, rw.StatusCode, rw.BytesWritten, delta, p, stack)
 		} else {
-			logRequest(r, http.StatusOK, delta)
+			logRequest(r, http.StatusOK, rw.BytesWritten, delta, p, stack)
 		}
 	}()
 
@@ -214,6 +302,10 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) serveRequest(w http.ResponseWriter, r *http.Request) {
+	if h.handleCORS(w, r) {
+		return
+	}
+
 	mime := r.Header.Get("Accept")
 	if mime != "" && mime != "*/*" && h.accept != "" && mime != h.accept {
 		w.Header().Set("Accept", h.accept)
@@ -236,6 +328,14 @@ func (h *Handler) serveRequest(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+
+	if h.compress {
+		if cw, ok := newCompressWriter(w, r, h.compressMinSize, h.compressLevel); ok {
+			defer cw.Close()
+			w = cw
+		}
+	}
+
 	h.inner.ServeHTTP(w, r)
 }
 
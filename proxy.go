@@ -0,0 +1,237 @@
+package httputil
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type contextKey int
+
+const (
+	clientIPKey contextKey = iota
+	clientProtoKey
+)
+
+// TrustProxies instructs the handler to trust the given CIDR ranges
+// as reverse proxies. Only when a request's r.RemoteAddr falls within
+// one of these ranges are the X-Forwarded-For, X-Real-IP, Forwarded,
+// and X-Forwarded-Proto headers honored when resolving the client's
+// real IP and protocol; see ClientIP and ClientProto. Requests from
+// untrusted addresses fall back to the host part of r.RemoteAddr,
+// with no header consulted.
+func (h *Handler) TrustProxies(cidrs ...string) error {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		nets = append(nets, n)
+	}
+	h.trustedProxies = nets
+	return nil
+}
+
+// ClientIP returns the resolved client IP address for r: the real
+// client behind any trusted proxies if the handler serving r called
+// TrustProxies, or r.RemoteAddr's host otherwise.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	return remoteHost(r.RemoteAddr)
+}
+
+// ClientProto returns the resolved client-facing scheme ("http" or
+// "https") for r, honoring X-Forwarded-Proto and Forwarded headers
+// when r arrived via a trusted proxy. Otherwise it's inferred from
+// the connection itself.
+func ClientProto(r *http.Request) string {
+	if proto, ok := r.Context().Value(clientProtoKey).(string); ok {
+		return proto
+	}
+	return directProto(r)
+}
+
+func directProto(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// trustedIP reports whether ip falls within one of the handler's
+// trusted proxy ranges.
+func (h *Handler) trustedIP(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range h.trustedProxies {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// trusted reports whether remoteAddr (an r.RemoteAddr, host:port)
+// belongs to a trusted proxy.
+func (h *Handler) trusted(remoteAddr string) bool {
+	if len(h.trustedProxies) == 0 {
+		return false
+	}
+	return h.trustedIP(remoteHost(remoteAddr))
+}
+
+// resolveClient resolves r's client IP and protocol according to the
+// handler's trusted proxy configuration and attaches both to r's
+// context for ClientIP and ClientProto to find.
+func (h *Handler) resolveClient(r *http.Request) *http.Request {
+	ip := remoteHost(r.RemoteAddr)
+	proto := directProto(r)
+
+	if h.trusted(r.RemoteAddr) {
+		resolvedProto := ""
+
+		switch {
+		case r.Header.Get("Forwarded") != "":
+			if fip, fproto, ok := parseForwarded(r.Header.Get("Forwarded"), h.trustedIP); ok {
+				ip = fip
+				resolvedProto = fproto
+			}
+		case r.Header.Get("X-Forwarded-For") != "":
+			if cip, ok := resolveXFF(r.Header.Get("X-Forwarded-For"), h.trustedIP); ok {
+				ip = cip
+			}
+		case r.Header.Get("X-Real-IP") != "":
+			ip = r.Header.Get("X-Real-IP")
+		}
+
+		if resolvedProto != "" {
+			proto = resolvedProto
+		} else if p := r.Header.Get("X-Forwarded-Proto"); p != "" {
+			proto = p
+		}
+	}
+
+	ctx := context.WithValue(r.Context(), clientIPKey, ip)
+	ctx = context.WithValue(ctx, clientProtoKey, proto)
+	return r.WithContext(ctx)
+}
+
+// resolveXFF walks a comma-separated X-Forwarded-For chain
+// right-to-left (nearest hop first), skipping entries that are
+// themselves trusted proxies, and returns the first (i.e. nearest
+// untrusted, closest to the real client) address found. If every hop
+// is trusted, it falls back to the left-most entry, which is the
+// original client as seen by the first proxy in the chain.
+func resolveXFF(xff string, trustedIP func(string) bool) (string, bool) {
+	parts := strings.Split(xff, ",")
+
+	for i := len(parts) - 1; i >= 0; i-- {
+		candidate := strings.TrimSpace(parts[i])
+		if candidate == "" || trustedIP(candidate) {
+			continue
+		}
+		return candidate, true
+	}
+
+	if len(parts) > 0 {
+		if first := strings.TrimSpace(parts[0]); first != "" {
+			return first, true
+		}
+	}
+	return "", false
+}
+
+// forwardedElement is a single comma-separated hop of an RFC 7239
+// Forwarded header.
+type forwardedElement struct {
+	for_  string
+	proto string
+}
+
+// parseForwarded walks a Forwarded header's comma-separated elements
+// right-to-left the same way resolveXFF walks X-Forwarded-For,
+// returning the resolved client IP and, if present, protocol.
+func parseForwarded(header string, trustedIP func(string) bool) (ip, proto string, ok bool) {
+	elems := strings.Split(header, ",")
+
+	var fallbackIP, fallbackProto string
+	haveFallback := false
+
+	for i := len(elems) - 1; i >= 0; i-- {
+		el := parseForwardedElement(elems[i])
+		if el.for_ == "" {
+			continue
+		}
+		candidate := stripForwardedFor(el.for_)
+
+		// Keep overwriting, so the fallback ends up as the left-most
+		// element, as in resolveXFF.
+		fallbackIP, fallbackProto = candidate, el.proto
+		haveFallback = true
+		if trustedIP(candidate) {
+			continue
+		}
+		return candidate, el.proto, true
+	}
+
+	if haveFallback {
+		return fallbackIP, fallbackProto, true
+	}
+	return "", "", false
+}
+
+// parseForwardedElement parses the for= and proto= tokens of a single
+// Forwarded header element. Other tokens, including host=, are
+// ignored.
+func parseForwardedElement(s string) forwardedElement {
+	var el forwardedElement
+
+	for _, pair := range strings.Split(s, ";") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "for":
+			el.for_ = strings.TrimSpace(kv[1])
+		case "proto":
+			el.proto = unquoteForwarded(strings.TrimSpace(kv[1]))
+		}
+	}
+	return el
+}
+
+// unquoteForwarded strips the double quotes a Forwarded token value
+// is wrapped in when it contains characters (such as the ':' and
+// brackets of a quoted IPv6 address) not allowed in a bare token.
+func unquoteForwarded(v string) string {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// stripForwardedFor extracts the bare IP from a Forwarded for= value,
+// which may be quoted, bracketed (IPv6), and/or carry a port, e.g.
+// `"[2001:db8:cafe::17]:4711"`.
+func stripForwardedFor(v string) string {
+	v = unquoteForwarded(v)
+	if host, _, err := net.SplitHostPort(v); err == nil {
+		return host
+	}
+	return strings.Trim(v, "[]")
+}
@@ -0,0 +1,55 @@
+package httputil
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicHandler is invoked with the recovered panic value and its
+// stack trace whenever a Handler recovers from a panic in its inner
+// http.Handler, after the error response has been written.
+type PanicHandler func(w http.ResponseWriter, r *http.Request, p interface{}, stack []byte)
+
+// PrintStack controls whether a recovered panic's stack trace is
+// printed via the standard logger. It defaults to true.
+func (h *Handler) PrintStack(print bool) {
+	h.printStack = print
+}
+
+// OnPanic registers a hook invoked whenever the handler recovers from
+// a panic in its inner http.Handler, in addition to the panic being
+// reported to any channels registered via Notify (see Access.Panic
+// and Access.Stack). Use it to route panics to error-tracking
+// services such as Sentry or Rollbar without shadowing the default
+// logger.
+func (h *Handler) OnPanic(fn PanicHandler) {
+	h.panicHandler = fn
+}
+
+// onPanic handles a panic p already recovered by the caller: it
+// writes a content-type-aware error response via Error, optionally
+// prints the stack trace, and invokes any registered PanicHandler.
+// It returns the stack trace captured for the panic so the caller
+// can report it on the request's Access event.
+//
+// recover() only works when called directly by a deferred function,
+// so the caller must recover the panic itself and pass the value in;
+// calling recover() here would be one frame too deep to catch it.
+func (h *Handler) onPanic(rw *ResponseWriter, r *http.Request, p interface{}) []byte {
+	stack := debug.Stack()
+
+	if h.printStack {
+		log.Printf("panic: %v\n%s", p, stack)
+	}
+
+	if !rw.HasStatus() {
+		Error(rw, "internal server error", http.StatusInternalServerError)
+	}
+
+	if h.panicHandler != nil {
+		h.panicHandler(rw, r, p, stack)
+	}
+
+	return stack
+}